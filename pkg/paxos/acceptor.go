@@ -0,0 +1,30 @@
+package paxos
+
+// acceptor holds the state a Paxos acceptor keeps across rounds: the
+// highest round it has promised not to ignore (rnd), the highest round
+// it has voted in along with that vote's value (vrnd/vval), and the
+// highest seqn it knows to be committed.
+type acceptor struct {
+	rnd, vrnd uint64
+	vval      string
+	lastSeqn  uint64
+}
+
+// HandleInvite responds to an invite the way an acceptor should. If the
+// inviter's lastSeqn trails this acceptor's own, the coordinator is
+// behind -- missed commits -- so the invite is nacked: the rsvp carries
+// nackVrnd instead of our real vrnd, telling the inviter to back off
+// rather than proceed as though it had won the round. Otherwise the
+// round is promised and the acceptor's highest vote so far is returned.
+func (a *acceptor) HandleInvite(m Msg) Msg {
+	crnd, lastSeqn := inviteParts(m)
+
+	if acceptorShouldNack(lastSeqn, a.lastSeqn) {
+		return newNackRsvp(crnd, a.lastSeqn)
+	}
+
+	if crnd > a.rnd {
+		a.rnd = crnd
+	}
+	return newRsvp(crnd, a.vrnd, a.lastSeqn, a.vval, false)
+}
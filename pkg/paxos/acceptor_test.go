@@ -0,0 +1,29 @@
+package paxos
+
+import "testing"
+
+func TestHandleInviteNacksLaggingCoordinator(t *testing.T) {
+	a := &acceptor{lastSeqn: 5}
+	rsvp := a.HandleInvite(newInvite(1, 3))
+
+	_, vrnd, lastSeqn, _ := rsvpParts(rsvp)
+	if vrnd != nackVrnd {
+		t.Fatalf("vrnd = %d, want nackVrnd", vrnd)
+	}
+	if lastSeqn != 5 {
+		t.Fatalf("lastSeqn = %d, want 5", lastSeqn)
+	}
+}
+
+func TestHandleInvitePromisesCaughtUpCoordinator(t *testing.T) {
+	a := &acceptor{lastSeqn: 5, vrnd: 2, vval: "prior"}
+	rsvp := a.HandleInvite(newInvite(7, 5))
+
+	_, vrnd, _, vval := rsvpParts(rsvp)
+	if vrnd != 2 || vval != "prior" {
+		t.Fatalf("got vrnd=%d vval=%q, want vrnd=2 vval=\"prior\"", vrnd, vval)
+	}
+	if a.rnd != 7 {
+		t.Fatalf("rnd = %d, want 7 after promising", a.rnd)
+	}
+}
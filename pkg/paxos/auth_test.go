@@ -0,0 +1,100 @@
+package paxos
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	key := []byte("cluster-secret")
+	m := newNominate(1, "hello", false)
+
+	signed := m.Sign(key)
+	if !signed.Verify(key, 0) {
+		t.Fatal("Verify on freshly signed Msg = false, want true")
+	}
+	if signed.Verify(key, signed.Nonce()) {
+		t.Fatal("Verify with lastNonce == Nonce should reject replay")
+	}
+	if signed.Verify([]byte("wrong-key"), 0) {
+		t.Fatal("Verify with wrong key = true, want false")
+	}
+
+	decoded, err := signed.Decompress()
+	if err != nil {
+		t.Fatalf("Decompress: %v", err)
+	}
+	crnd, v := nominateParts(decoded)
+	if crnd != 1 || v != "hello" {
+		t.Fatalf("got crnd=%d v=%q, want crnd=1 v=%q", crnd, v, "hello")
+	}
+}
+
+func TestVerifyRejectsShortMessageWithoutPanicking(t *testing.T) {
+	key := []byte("cluster-secret")
+	for n := 0; n < baseLen; n++ {
+		m := make(Msg, n)
+		if m.Verify(key, 0) {
+			t.Fatalf("len(m)=%d: Verify() = true, want false", n)
+		}
+	}
+}
+
+// TestVerifyRejectsFlippedCmdFlagBit ensures the HMAC trailer covers the
+// literal on-wire mCmd byte, flags included -- not just the masked
+// command id -- so tampering with cmdCompressed/cmdAuthed after signing
+// is caught rather than silently changing how a receiver interprets an
+// already-signed body.
+func TestVerifyRejectsFlippedCmdFlagBit(t *testing.T) {
+	key := []byte("cluster-secret")
+	// Start from a compressed Msg and clear cmdCompressed, so the tampered
+	// Msg still passes Ok() and only the HMAC check can reject it.
+	signed := newNominate(1, strings.Repeat("x", compressThreshold+1), true).Sign(key)
+	if !signed.Verify(key, 0) {
+		t.Fatal("Verify on freshly signed Msg = false, want true")
+	}
+
+	tampered := make(Msg, len(signed))
+	copy(tampered, signed)
+	tampered[mCmd] &^= cmdCompressed
+
+	if tampered.Verify(key, 0) {
+		t.Fatal("Verify accepted a Msg with a flipped cmdCompressed bit")
+	}
+}
+
+// TestSignVerifyWithCompressionRoundTrip exercises compression and
+// authentication together: Verify must run on the still-compressed,
+// still-authenticated Msg (the trailer covers the compressed body), and
+// only then should Decompress produce the plaintext Msg.
+func TestSignVerifyWithCompressionRoundTrip(t *testing.T) {
+	key := []byte("cluster-secret")
+	v := strings.Repeat("x", compressThreshold+1)
+	m := newNominate(1, v, true)
+	if !m.Compressed() {
+		t.Fatal("expected newNominate with compress=true and a large body to compress")
+	}
+
+	signed := m.Sign(key)
+	if !signed.Compressed() || !signed.Authenticated() {
+		t.Fatal("signed Msg should be both Compressed and Authenticated")
+	}
+	if !signed.Ok() {
+		t.Fatal("Ok() on a valid signed+compressed Msg = false, want true")
+	}
+	if !signed.Verify(key, 0) {
+		t.Fatal("Verify on signed+compressed Msg = false, want true")
+	}
+
+	decoded, err := signed.Decompress()
+	if err != nil {
+		t.Fatalf("Decompress: %v", err)
+	}
+	if decoded.Authenticated() {
+		t.Fatal("Decompress should strip cmdAuthed; Verify must run before Decompress, not after")
+	}
+	_, gotV := nominateParts(decoded)
+	if gotV != v {
+		t.Fatal("decompressed value does not match original")
+	}
+}
@@ -0,0 +1,82 @@
+package paxos
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// codecRoundTrip encodes m with c, decodes it back, then fills in From --
+// exactly as a real caller would after reading off a UDP/TCP socket,
+// since WireBytes (and so every Codec) never carries the sender index --
+// and returns the result for comparison against m.
+func codecRoundTrip(t *testing.T, c Codec, m Msg) Msg {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := c.Encode(m, &buf); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	got, err := c.Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	got.SetFrom(m.From())
+	return got
+}
+
+func TestUDPCodecRoundTrip(t *testing.T) {
+	m := newNominate(1, "hello", false)
+	m.SetFrom(3)
+	m.SetSeqn(42)
+
+	got := codecRoundTrip(t, UDPCodec{}, m)
+	if !bytes.Equal(got.WireBytes(), m.WireBytes()) {
+		t.Fatalf("got %v, want %v", []byte(got.WireBytes()), []byte(m.WireBytes()))
+	}
+	if got.From() != m.From() || got.Seqn() != m.Seqn() {
+		t.Fatalf("got From=%d Seqn=%d, want From=%d Seqn=%d", got.From(), got.Seqn(), m.From(), m.Seqn())
+	}
+}
+
+func TestTCPCodecRoundTrip(t *testing.T) {
+	m := newPropose(strings.Repeat("v", 2000), false)
+	m.SetFrom(5)
+	m.SetSeqn(99)
+
+	got := codecRoundTrip(t, TCPCodec{}, m)
+	if !bytes.Equal(got.WireBytes(), m.WireBytes()) {
+		t.Fatalf("wire bytes mismatch after TCPCodec round trip")
+	}
+	if got.From() != m.From() || got.Seqn() != m.Seqn() {
+		t.Fatalf("got From=%d Seqn=%d, want From=%d Seqn=%d", got.From(), got.Seqn(), m.From(), m.Seqn())
+	}
+}
+
+func TestTCPCodecEncodeRejectsOversizedMsg(t *testing.T) {
+	m := newPropose(strings.Repeat("v", 0x10000), false)
+
+	var buf bytes.Buffer
+	if err := (TCPCodec{}).Encode(m, &buf); err != ErrMsgTooLarge {
+		t.Fatalf("got err %v, want ErrMsgTooLarge", err)
+	}
+}
+
+func TestTransportDefaultsToUDPCodec(t *testing.T) {
+	m := newTick()
+	m.SetSeqn(1)
+
+	var tr Transport
+	var buf bytes.Buffer
+	if err := tr.Send(m, &buf); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	got, err := tr.Recv(&buf)
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	if !bytes.Equal(got.WireBytes(), m.WireBytes()) {
+		t.Fatal("Transport zero value did not round-trip like UDPCodec")
+	}
+}
@@ -2,6 +2,8 @@ package paxos
 
 import (
 	"junta/util"
+
+	"github.com/golang/snappy"
 )
 
 // In-memory format:
@@ -57,35 +59,75 @@ const (
 	vote
 	tick
 	propose
+	nominateShard
 )
 
 const (
-	inviteLen   = 8
-	rsvpLen     = 16 // not including v
-	nominateLen = 8  // not including v
-	voteLen     = 8  // not including v
-	tickLen     = 0
-	proposeLen  = 0 // not including v
+	inviteLen        = 16
+	rsvpLen          = 24 // not including v
+	nominateLen      = 8  // not including v
+	voteLen          = 8  // not including v
+	tickLen          = 0
+	proposeLen       = 0  // not including v
+	nominateShardLen = 15 // not including the shard bytes
 )
 
-func newInvite(crnd uint64) Msg {
+// nackVrnd is the vrnd an acceptor sends back in an rsvp to mean "I'm
+// refusing this invite", rather than "I've never voted". A proposer
+// seeing it back off instead of treating the round as won.
+const nackVrnd = ^uint64(0) // math.MaxUint64
+
+// cmdCompressed and cmdAuthed are flag bits stolen from the top of the
+// mCmd byte. cmdCompressed means Body() holds a snappy-compressed
+// payload (see Decompress); cmdAuthed means the message carries a nonce
+// and an HMAC trailer (see Sign/Verify). Both default to unset, so peers
+// that don't understand them are unaffected.
+const cmdCompressed = 0x80
+const cmdAuthed = 0x40
+const cmdFlags = cmdCompressed | cmdAuthed
+const cmdMask = 0xff &^ cmdFlags
+
+// compressThreshold is the raw body size above which newNominate/newRsvp/
+// newVote/newPropose snappy-compress the body when asked to; below it,
+// compressing would cost more than it saves.
+const compressThreshold = 256
+
+// newMsg builds a Msg for cmd from an already-laid-out body, compressing
+// it and setting cmdCompressed when compress is true and the body is
+// bigger than compressThreshold.
+func newMsg(cmd int, body []byte, compress bool) Msg {
+	if compress && len(body) > compressThreshold {
+		body = snappy.Encode(nil, body)
+		cmd |= cmdCompressed
+	}
+	m := make(Msg, baseLen+len(body))
+	m[mCmd] = byte(cmd)
+	copy(m.Body(), body)
+	return m
+}
+
+func newInvite(crnd, lastSeqn uint64) Msg {
 	m := make(Msg, baseLen+inviteLen)
 	m[mCmd] = invite
 	util.Packui64(m.Body()[0:8], crnd)
+	util.Packui64(m.Body()[8:16], lastSeqn)
 	return m
 }
 
-// Returns the info for `m`. If `m` is not an invite, the result is undefined.
-func inviteParts(m Msg) (crnd uint64) {
-	return util.Unpackui64(m.Body())
+// Returns the info for `m`. If `m` is not an invite, the result is
+// undefined. `lastSeqn` is the sender's highest-known committed seqn,
+// used by the responder to detect a coordinator that's behind.
+func inviteParts(m Msg) (crnd, lastSeqn uint64) {
+	crnd = util.Unpackui64(m.Body()[0:8])
+	lastSeqn = util.Unpackui64(m.Body()[8:16])
+	return
 }
 
-func newNominate(crnd uint64, v string) Msg {
-	m := make(Msg, baseLen+nominateLen+len(v))
-	m[mCmd] = nominate
-	util.Packui64(m.Body()[0:8], crnd)
-	copy(m.Body()[nominateLen:], []byte(v))
-	return m
+func newNominate(crnd uint64, v string, compress bool) Msg {
+	body := make([]byte, nominateLen+len(v))
+	util.Packui64(body[0:8], crnd)
+	copy(body[nominateLen:], []byte(v))
+	return newMsg(nominate, body, compress)
 }
 
 // Returns the info for `m`. If `m` is not a nominate, the result is undefined.
@@ -95,29 +137,47 @@ func nominateParts(m Msg) (crnd uint64, v string) {
 	return
 }
 
-func newRsvp(i, vrnd uint64, vval string) Msg {
-	m := make(Msg, baseLen+rsvpLen+len(vval))
-	m[mCmd] = rsvp
-	util.Packui64(m.Body()[0:8], i)
-	util.Packui64(m.Body()[8:16], vrnd)
-	copy(m.Body()[rsvpLen:], []byte(vval))
-	return m
+func newRsvp(i, vrnd, lastSeqn uint64, vval string, compress bool) Msg {
+	body := make([]byte, rsvpLen+len(vval))
+	util.Packui64(body[0:8], i)
+	util.Packui64(body[8:16], vrnd)
+	util.Packui64(body[16:24], lastSeqn)
+	copy(body[rsvpLen:], []byte(vval))
+	return newMsg(rsvp, body, compress)
 }
 
-// Returns the info for `m`. If `m` is not an rsvp, the result is undefined.
-func rsvpParts(m Msg) (i, vrnd uint64, vval string) {
+// newNackRsvp builds the rsvp an acceptor sends in response to an invite
+// whose lastSeqn trails its own: vrnd is set to nackVrnd so the inviter
+// knows to back off rather than proceed as coordinator.
+func newNackRsvp(i, lastSeqn uint64) Msg {
+	return newRsvp(i, nackVrnd, lastSeqn, "", false)
+}
+
+// Returns the info for `m`. If `m` is not an rsvp, the result is
+// undefined. `lastSeqn` is the responder's highest-known committed seqn;
+// a `vrnd` of nackVrnd means the responder refused the invite because its
+// lastSeqn was ahead of the inviter's.
+func rsvpParts(m Msg) (i, vrnd, lastSeqn uint64, vval string) {
 	i = util.Unpackui64(m.Body()[0:8])
 	vrnd = util.Unpackui64(m.Body()[8:16])
-	vval = string(m.Body()[16:])
+	lastSeqn = util.Unpackui64(m.Body()[16:24])
+	vval = string(m.Body()[24:])
 	return
 }
 
-func newVote(i uint64, vval string) Msg {
-	m := make(Msg, baseLen+voteLen+len(vval))
-	m[mCmd] = vote
-	util.Packui64(m.Body()[0:8], i)
-	copy(m.Body()[voteLen:], []byte(vval))
-	return m
+// acceptorShouldNack reports whether an invite claiming lastSeqn should be
+// refused because the acceptor has committed further than that: a node
+// that missed commits must not be allowed to win coordinatorship on a
+// fresh round.
+func acceptorShouldNack(inviteLastSeqn, acceptorLastSeqn uint64) bool {
+	return inviteLastSeqn < acceptorLastSeqn
+}
+
+func newVote(i uint64, vval string, compress bool) Msg {
+	body := make([]byte, voteLen+len(vval))
+	util.Packui64(body[0:8], i)
+	copy(body[voteLen:], []byte(vval))
+	return newMsg(vote, body, compress)
 }
 
 // Returns the info for `m`. If `m` is not a vote, the result is undefined.
@@ -133,11 +193,10 @@ func newTick() Msg {
 	return m
 }
 
-func newPropose(val string) Msg {
-	m := make(Msg, baseLen+proposeLen+len(val))
-	m[mCmd] = propose
-	copy(m.Body()[proposeLen:], []byte(val))
-	return m
+func newPropose(val string, compress bool) Msg {
+	body := make([]byte, proposeLen+len(val))
+	copy(body[proposeLen:], []byte(val))
+	return newMsg(propose, body, compress)
 }
 
 // Returns the info for `m`. If `m` is not a propose, the result is undefined.
@@ -150,15 +209,56 @@ func (m Msg) From() int {
 	return int(m[mFrom])
 }
 
+// Cmd returns the message's command, with the cmdCompressed and cmdAuthed
+// flag bits masked off. Use Compressed and Authenticated to test them.
 func (m Msg) Cmd() int {
-	return int(m[mCmd])
+	return int(m[mCmd]) & cmdMask
+}
+
+// Compressed reports whether m's body is snappy-compressed. Callers that
+// interpret Body() directly (nominateParts, rsvpParts, voteParts,
+// proposeParts, ...) need to call Decompress first when this is true.
+func (m Msg) Compressed() bool {
+	return m[mCmd]&cmdCompressed != 0
+}
+
+// Decompress returns m with its body snappy-decompressed if Compressed
+// reports true, or m unchanged otherwise. Call this once, right after
+// reading a Msg off the wire, before handing it to anything that
+// interprets Body().
+//
+// If m is also Authenticated, Decompress strips the auth trailer and
+// nonce along with the cmdAuthed flag, so the result can no longer be
+// Verified. Call Verify before Decompress, never after -- the HMAC
+// trailer is computed over the (possibly still-compressed) signed body,
+// so Decompress must run on the already-verified Msg, not the reverse.
+func (m Msg) Decompress() (Msg, error) {
+	if !m.Compressed() {
+		return m, nil
+	}
+	body, err := snappy.Decode(nil, m.Body())
+	if err != nil {
+		return nil, err
+	}
+	out := make(Msg, baseLen+len(body))
+	out[mFrom] = m[mFrom]
+	out[mCmd] = byte(m.Cmd())
+	copy(out[mSeqn:mBody], m[mSeqn:mBody])
+	copy(out.Body(), body)
+	return out, nil
 }
 
 func (m Msg) Seqn() uint64 {
 	return util.Unpackui64(m[mSeqn : mSeqn+8])
 }
 
+// Body returns the portion of m that holds the command-specific payload.
+// When m is Authenticated, that's everything after the nonce and before
+// the HMAC trailer; otherwise it's everything after mBody.
 func (m Msg) Body() []byte {
+	if m.Authenticated() {
+		return m[mBody+nonceLen : len(m)-trailerLen]
+	}
 	return m[mBody:]
 }
 
@@ -182,15 +282,33 @@ func (m Msg) Ok() bool {
 	if len(m) < 2 {
 		return false
 	}
+	if len(m) < baseLen {
+		return false
+	}
+	if m.Authenticated() && len(m) < mBody+nonceLen+trailerLen {
+		return false
+	}
+
+	bodyLen := len(m.Body())
+	if m.Compressed() {
+		n, err := snappy.DecodedLen(m.Body())
+		if err != nil {
+			return false
+		}
+		bodyLen = n
+	}
+
 	switch m.Cmd() {
 	case invite:
-		return len(m.Body()) == inviteLen
+		return bodyLen == inviteLen
 	case rsvp:
-		return len(m.Body()) >= rsvpLen
+		return bodyLen >= rsvpLen
 	case nominate:
-		return len(m.Body()) >= nominateLen
+		return bodyLen >= nominateLen
 	case vote:
-		return len(m.Body()) >= voteLen
+		return bodyLen >= voteLen
+	case nominateShard:
+		return bodyLen >= nominateShardLen
 	}
 	return false
 }
@@ -0,0 +1,12 @@
+package paxos
+
+import "testing"
+
+func TestOkRejectsShortMessageWithoutPanicking(t *testing.T) {
+	for n := 0; n < baseLen; n++ {
+		m := make(Msg, n)
+		if m.Ok() {
+			t.Fatalf("len(m)=%d: Ok() = true, want false", n)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package paxos
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"junta/util"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// nominateShard body layout (not including the shard bytes themselves):
+//
+//     0..7   -- crnd
+//     8      -- shardIdx
+//     9      -- dataShards
+//     10     -- parityShards
+//     11..14 -- totalLen (length of the original, unsharded v)
+//     15..   -- shardBytes
+
+func newNominateShard(crnd uint64, shardIdx, dataShards, parityShards int, totalLen int, shard []byte) Msg {
+	m := make(Msg, baseLen+nominateShardLen+len(shard))
+	m[mCmd] = nominateShard
+	util.Packui64(m.Body()[0:8], crnd)
+	m.Body()[8] = byte(shardIdx)
+	m.Body()[9] = byte(dataShards)
+	m.Body()[10] = byte(parityShards)
+	putUint32(m.Body()[11:15], uint32(totalLen))
+	copy(m.Body()[nominateShardLen:], shard)
+	return m
+}
+
+// Returns the info for `m`. If `m` is not a nominateShard, the result is
+// undefined.
+func nominateShardParts(m Msg) (crnd uint64, shardIdx, dataShards, parityShards, totalLen int, shard []byte) {
+	crnd = util.Unpackui64(m.Body()[0:8])
+	shardIdx = int(m.Body()[8])
+	dataShards = int(m.Body()[9])
+	parityShards = int(m.Body()[10])
+	totalLen = int(getUint32(m.Body()[11:15]))
+	shard = m.Body()[nominateShardLen:]
+	return
+}
+
+func putUint32(b []byte, v uint32) {
+	b[0] = byte(v >> 24)
+	b[1] = byte(v >> 16)
+	b[2] = byte(v >> 8)
+	b[3] = byte(v)
+}
+
+func getUint32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+// shardTimeout is how long a partial shard set is kept around waiting for
+// enough shards to reconstruct `v` before it's evicted, so loss on a
+// lossy link can't grow memory without bound.
+const shardTimeout = 30 * time.Second
+
+// shardKey identifies one nominate's worth of shards.
+type shardKey struct {
+	seqn uint64
+	crnd uint64
+}
+
+type shardSet struct {
+	dataShards, parityShards int
+	totalLen                 int
+	shards                   [][]byte
+	have                     int
+	deadline                 time.Time
+}
+
+// shardAssembler buffers nominateShard messages keyed by (seqn, crnd) and
+// reconstructs the original `v` once any `dataShards` of them have
+// arrived, so large nominate/propose values can ride on UDP as a set of
+// small, loss-tolerant shards instead of one oversized packet.
+type shardAssembler struct {
+	mu   sync.Mutex
+	sets map[shardKey]*shardSet
+}
+
+func newShardAssembler() *shardAssembler {
+	return &shardAssembler{sets: make(map[shardKey]*shardSet)}
+}
+
+// ErrInvalidShard is returned by shardAssembler.Add when a nominateShard
+// message's shardIdx/dataShards/parityShards fields are out of range --
+// these come straight off the wire via nominateShardParts, so they must
+// be rejected rather than used to index a slice.
+var ErrInvalidShard = errors.New("paxos: invalid shard index or shard counts")
+
+// Add buffers one shard of a sharded nominate. Once `dataShards` of the
+// `dataShards+parityShards` shards for this (seqn, crnd) have arrived, it
+// reconstructs and returns the original value with ok set to true.
+func (a *shardAssembler) Add(seqn uint64, crnd uint64, shardIdx, dataShards, parityShards, totalLen int, shard []byte) (v string, ok bool, err error) {
+	total := dataShards + parityShards
+	if dataShards <= 0 || parityShards < 0 || shardIdx < 0 || shardIdx >= total {
+		return "", false, ErrInvalidShard
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.evictExpired()
+
+	key := shardKey{seqn, crnd}
+	set, found := a.sets[key]
+	if !found {
+		set = &shardSet{
+			dataShards:   dataShards,
+			parityShards: parityShards,
+			totalLen:     totalLen,
+			shards:       make([][]byte, total),
+			deadline:     time.Now().Add(shardTimeout),
+		}
+		a.sets[key] = set
+	}
+	if dataShards != set.dataShards || parityShards != set.parityShards || shardIdx >= len(set.shards) {
+		return "", false, ErrInvalidShard
+	}
+
+	if set.shards[shardIdx] == nil {
+		buf := make([]byte, len(shard))
+		copy(buf, shard)
+		set.shards[shardIdx] = buf
+		set.have++
+	}
+
+	if set.have < set.dataShards {
+		return "", false, nil
+	}
+
+	enc, err := reedsolomon.New(set.dataShards, set.parityShards)
+	if err != nil {
+		return "", false, err
+	}
+	if err := enc.Reconstruct(set.shards); err != nil {
+		return "", false, err
+	}
+
+	buf := make([]byte, set.totalLen)
+	n := 0
+	for _, s := range set.shards[:set.dataShards] {
+		n += copy(buf[n:], s)
+		if n >= set.totalLen {
+			break
+		}
+	}
+	delete(a.sets, key)
+	return string(buf[:set.totalLen]), true, nil
+}
+
+// evictExpired drops shard sets that never reached k shards within
+// shardTimeout. Callers must hold a.mu.
+func (a *shardAssembler) evictExpired() {
+	now := time.Now()
+	for key, set := range a.sets {
+		if now.After(set.deadline) {
+			delete(a.sets, key)
+		}
+	}
+}
+
+// maxShardCount is the largest dataShards+parityShards splitNominate will
+// accept: shardIdx and both counts are packed into a single byte each on
+// the wire (nominateShardParts reads them back with `int(m.Body()[8])`,
+// `int(m.Body()[9])` and `int(m.Body()[10])`), and shardIdx runs up to
+// dataShards+parityShards-1, so keeping the sum within 255 keeps all
+// three from silently truncating mod 256.
+const maxShardCount = 255
+
+// ErrTooManyShards is returned by splitNominate when dataShards+
+// parityShards exceeds maxShardCount.
+var ErrTooManyShards = errors.New("paxos: dataShards+parityShards exceeds maxShardCount")
+
+// splitNominate splits v into dataShards+parityShards shards and returns
+// one nominateShard Msg per shard, ready to be sent to acceptors in place
+// of a single, possibly oversized, nominate.
+func splitNominate(crnd uint64, v string, dataShards, parityShards int) ([]Msg, error) {
+	if dataShards+parityShards > maxShardCount {
+		return nil, ErrTooManyShards
+	}
+
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	shards, err := enc.Split([]byte(v))
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(shards); err != nil {
+		return nil, err
+	}
+
+	msgs := make([]Msg, len(shards))
+	for i, shard := range shards {
+		msgs[i] = newNominateShard(crnd, i, dataShards, parityShards, len(v), shard)
+	}
+	return msgs, nil
+}
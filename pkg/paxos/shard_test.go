@@ -0,0 +1,67 @@
+package paxos
+
+import "testing"
+
+func TestSplitNominateRoundTrip(t *testing.T) {
+	v := "hello from a sharded nominate"
+	msgs, err := splitNominate(7, v, 3, 2)
+	if err != nil {
+		t.Fatalf("splitNominate: %v", err)
+	}
+
+	asm := newShardAssembler()
+	var got string
+	var ok bool
+	// Drop one shard and reconstruct from the rest, since that's the
+	// point of adding parity shards.
+	for _, m := range msgs[1:] {
+		crnd, shardIdx, dataShards, parityShards, totalLen, shard := nominateShardParts(m)
+		got, ok, err = asm.Add(1, crnd, shardIdx, dataShards, parityShards, totalLen, shard)
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if ok {
+			break
+		}
+	}
+	if !ok {
+		t.Fatal("expected reconstruction to succeed with k shards")
+	}
+	if got != v {
+		t.Fatalf("got %q, want %q", got, v)
+	}
+}
+
+func TestSplitNominateRejectsTooManyShards(t *testing.T) {
+	if _, err := splitNominate(1, "v", 200, 100); err != ErrTooManyShards {
+		t.Fatalf("got err %v, want ErrTooManyShards", err)
+	}
+}
+
+func TestShardAssemblerRejectsOutOfRangeShardIdx(t *testing.T) {
+	asm := newShardAssembler()
+	_, ok, err := asm.Add(1, 1, 200, 2, 1, 50, []byte("x"))
+	if err != ErrInvalidShard {
+		t.Fatalf("got err %v, want ErrInvalidShard", err)
+	}
+	if ok {
+		t.Fatal("expected ok=false for an invalid shard")
+	}
+}
+
+func TestShardAssemblerRejectsNonPositiveDataShards(t *testing.T) {
+	asm := newShardAssembler()
+	if _, _, err := asm.Add(1, 1, 0, 0, 1, 50, []byte("x")); err != ErrInvalidShard {
+		t.Fatalf("got err %v, want ErrInvalidShard", err)
+	}
+}
+
+func TestShardAssemblerRejectsMismatchedShardCounts(t *testing.T) {
+	asm := newShardAssembler()
+	if _, _, err := asm.Add(1, 1, 0, 2, 1, 50, []byte("x")); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if _, _, err := asm.Add(1, 1, 1, 3, 1, 50, []byte("x")); err != ErrInvalidShard {
+		t.Fatalf("got err %v, want ErrInvalidShard for a shard claiming different counts than the in-flight set", err)
+	}
+}
@@ -0,0 +1,27 @@
+package util
+
+// Packui64 writes v into b as 8 bytes of big-endian binary. It panics if
+// len(b) < 8.
+func Packui64(b []byte, v uint64) {
+	b[0] = byte(v >> 56)
+	b[1] = byte(v >> 48)
+	b[2] = byte(v >> 40)
+	b[3] = byte(v >> 32)
+	b[4] = byte(v >> 24)
+	b[5] = byte(v >> 16)
+	b[6] = byte(v >> 8)
+	b[7] = byte(v)
+}
+
+// Unpackui64 reads the first 8 bytes of b as big-endian binary. It
+// panics if len(b) < 8.
+func Unpackui64(b []byte) uint64 {
+	return uint64(b[0])<<56 |
+		uint64(b[1])<<48 |
+		uint64(b[2])<<40 |
+		uint64(b[3])<<32 |
+		uint64(b[4])<<24 |
+		uint64(b[5])<<16 |
+		uint64(b[6])<<8 |
+		uint64(b[7])
+}